@@ -0,0 +1,203 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/kubernetes/wait"
+	"github.com/grafana/tanka/pkg/spec/v1alpha1"
+)
+
+func testEnv() v1alpha1.Config {
+	return v1alpha1.Config{
+		Metadata: v1alpha1.Metadata{Name: "default", Namespace: "test"},
+		Spec:     v1alpha1.Spec{Namespace: "test"},
+	}
+}
+
+func TestApply_CallsApplyAndPersistsInventory(t *testing.T) {
+	fc := &fakeClient{}
+	k := &Kubernetes{Env: testEnv(), ctl: fc}
+
+	// AutoApprove must be set, since without it Apply blocks on stdin for
+	// interactive confirmation
+	if err := k.Apply(manifest.List{}, ApplyOpts{ApplyOpts: client.ApplyOpts{AutoApprove: true}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(fc.applyCalls) != 1 {
+		t.Fatalf("expected ctl.Apply to be called exactly once, got %d", len(fc.applyCalls))
+	}
+	if fc.saveInventoryCalls != 1 {
+		t.Errorf("expected the inventory to be persisted exactly once, got %d", fc.saveInventoryCalls)
+	}
+}
+
+func TestApply_PropagatesApplyError(t *testing.T) {
+	fc := &fakeClient{applyErr: errBoom}
+	k := &Kubernetes{Env: testEnv(), ctl: fc}
+
+	err := k.Apply(manifest.List{}, ApplyOpts{ApplyOpts: client.ApplyOpts{AutoApprove: true}})
+	if err != errBoom {
+		t.Fatalf("expected ctl.Apply's error to propagate, got %v", err)
+	}
+	if fc.saveInventoryCalls != 0 {
+		t.Errorf("expected inventory not to be persisted when Apply itself fails, got %d calls", fc.saveInventoryCalls)
+	}
+}
+
+func TestApply_WaitIsInvokedWhenRequested(t *testing.T) {
+	fc := &fakeClient{}
+	k := &Kubernetes{Env: testEnv(), ctl: fc}
+
+	opts := ApplyOpts{
+		ApplyOpts:   client.ApplyOpts{AutoApprove: true},
+		Wait:        true,
+		WaitTimeout: time.Millisecond,
+	}
+	// the fake client's Get reports NotFound, so the Pod never becomes ready;
+	// only Wait produces a wait.NotReadyError, so getting one back shows that
+	// Apply actually called through to it
+	state := manifest.List{
+		manifest.Manifest{
+			"kind": "Pod",
+			"metadata": map[string]interface{}{
+				"name":      "pending",
+				"namespace": "test",
+			},
+		},
+	}
+	if _, ok := k.Apply(state, opts).(wait.NotReadyError); !ok {
+		t.Fatalf("expected Apply to return Wait's wait.NotReadyError")
+	}
+}
+
+func TestApply_WithPrune_PersistsInventoryOnce(t *testing.T) {
+	fc := &fakeClient{}
+	k := &Kubernetes{Env: testEnv(), ctl: fc}
+
+	opts := ApplyOpts{
+		ApplyOpts: client.ApplyOpts{AutoApprove: true},
+		Prune:     &PruneOpts{},
+	}
+	if err := k.Apply(manifest.List{}, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if fc.deleteCalls != 1 {
+		t.Errorf("expected Prune to run exactly once, got %d Delete calls", fc.deleteCalls)
+	}
+	// Prune itself no longer persists the inventory - Apply does it once,
+	// after Prune returns. A regression back to both saving would double
+	// the ConfigMap writes on every `apply --prune` run
+	if fc.saveInventoryCalls != 1 {
+		t.Errorf("expected apply --prune to persist the inventory exactly once, got %d", fc.saveInventoryCalls)
+	}
+}
+
+func TestApply_PersistsInventoryAndPrunesEvenWhenWaitTimesOut(t *testing.T) {
+	fc := &fakeClient{}
+	k := &Kubernetes{Env: testEnv(), ctl: fc}
+
+	// the fake client's Get always reports NotFound, so Wait never sees the
+	// object come into existence and is guaranteed to still be pending when
+	// WaitTimeout elapses
+	state := manifest.List{
+		manifest.Manifest{
+			"kind": "Pod",
+			"metadata": map[string]interface{}{
+				"name":      "never-ready",
+				"namespace": "test",
+			},
+		},
+	}
+
+	opts := ApplyOpts{
+		ApplyOpts:   client.ApplyOpts{AutoApprove: true},
+		Wait:        true,
+		WaitTimeout: time.Millisecond,
+		Prune:       &PruneOpts{},
+	}
+
+	err := k.Apply(state, opts)
+	if _, ok := err.(wait.NotReadyError); !ok {
+		t.Fatalf("expected a wait.NotReadyError, got %v", err)
+	}
+
+	if fc.saveInventoryCalls != 1 {
+		t.Errorf("expected the inventory to still be persisted when Wait times out, got %d calls", fc.saveInventoryCalls)
+	}
+	if fc.deleteCalls != 1 {
+		t.Errorf("expected Prune to still run when Wait times out, got %d Delete calls", fc.deleteCalls)
+	}
+}
+
+func TestApply_PruneCatchesObjectRenamedSinceTheLastApply(t *testing.T) {
+	fc := &fakeClient{
+		// the old object is still on the cluster under its former name,
+		// just no longer produced by Jsonnet - GetByLabels never turns it
+		// up because apiResources (and thus the set of kinds scanned) is
+		// empty here, so only the inventory fallback can find it
+		getResults: map[manifest.Identifier]manifest.Manifest{
+			{Kind: "ConfigMap", Name: "old-name", Namespace: "test"}: {
+				"kind": "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name":      "old-name",
+					"namespace": "test",
+				},
+			},
+		},
+	}
+	k := &Kubernetes{Env: testEnv(), ctl: fc}
+
+	// first apply: persists "old-name" as the inventory
+	firstState := manifest.List{
+		manifest.Manifest{
+			"kind": "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      "old-name",
+				"namespace": "test",
+			},
+		},
+	}
+	if err := k.Apply(firstState, ApplyOpts{ApplyOpts: client.ApplyOpts{AutoApprove: true}}); err != nil {
+		t.Fatalf("unexpected error on first apply: %v", err)
+	}
+
+	// second apply: the object was renamed in Jsonnet, so the new state
+	// only contains "new-name". Had the inventory already been overwritten
+	// before Prune ran, loadInventory would return {new-name} instead of
+	// the real previous inventory {old-name}, and the rename would never
+	// be caught
+	secondState := manifest.List{
+		manifest.Manifest{
+			"kind": "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      "new-name",
+				"namespace": "test",
+			},
+		},
+	}
+	opts := ApplyOpts{
+		ApplyOpts: client.ApplyOpts{AutoApprove: true},
+		Prune:     &PruneOpts{},
+	}
+	if err := k.Apply(secondState, opts); err != nil {
+		t.Fatalf("unexpected error on second apply: %v", err)
+	}
+
+	if fc.deleteCalls != 1 {
+		t.Fatalf("expected the renamed-away object to be pruned, got %d Delete calls", fc.deleteCalls)
+	}
+	if len(fc.deletedData) != 1 || fc.deletedData[0].Identifier().Name != "old-name" {
+		t.Errorf("expected old-name to be the object pruned, got %+v", fc.deletedData)
+	}
+}
+
+var errBoom = &boomError{}
+
+type boomError struct{}
+
+func (*boomError) Error() string { return "boom" }
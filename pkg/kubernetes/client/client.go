@@ -0,0 +1,434 @@
+// Package client talks to the Kubernetes apiserver using `kubectl`
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+// Client bundles the methods required to talk to a Kubernetes cluster via
+// kubectl
+type Client struct {
+	// APIServer is the `--server` kubectl is invoked with
+	APIServer string
+
+	context gjson.Result
+	cluster gjson.Result
+}
+
+// Info holds information about the context `Client` operates in
+type Info struct {
+	Context       gjson.Result
+	Cluster       gjson.Result
+	ServerVersion *semver.Version
+}
+
+// Interface is the subset of Client's methods that Kubernetes and wait
+// depend on, broken out so tests can substitute a fake instead of shelling
+// out to kubectl
+type Interface interface {
+	Info() (*Info, error)
+	Apply(data manifest.List, opts ApplyOpts) error
+	DiffServerSide(data manifest.List) (*string, error)
+	DiffServerSideApply(data manifest.List, fieldManager string, forceConflicts bool) (*string, error)
+	GetByLabels(namespace, kind string, labels map[string]string) (manifest.List, error)
+	Get(namespace, kind, name string) (manifest.Manifest, error)
+	Delete(data manifest.List) error
+	GetInventory(namespace, name string) ([]Identifier, error)
+	SaveInventory(namespace, name string, ids []Identifier) error
+	APIResources() ([]APIResource, error)
+}
+
+var _ Interface = Client{}
+
+// New creates a new Client for the given apiServer
+func New(apiServer string) (Client, error) {
+	return Client{APIServer: apiServer}, nil
+}
+
+// Info returns information about the cluster, including its version
+func (k Client) Info() (*Info, error) {
+	return &Info{
+		Context:       k.context,
+		Cluster:       k.cluster,
+		ServerVersion: semver.MustParse("0.0.0"),
+	}, nil
+}
+
+// ApplyOpts allow to set additional parameters for Apply
+type ApplyOpts struct {
+	// AutoApprove skips the interactive approval
+	AutoApprove bool
+
+	// Strategy used to apply: `native` or `server-side`
+	Strategy string
+
+	// FieldManager used when applying with Server-Side Apply. Defaults to
+	// "tanka"
+	FieldManager string
+
+	// ForceConflicts instructs the apiserver to take ownership of fields
+	// already managed by another field manager, instead of failing
+	ForceConflicts bool
+}
+
+// Apply applies the given manifests to the cluster
+func (k Client) Apply(data manifest.List, opts ApplyOpts) error {
+	args := []string{"apply", "-f", "-"}
+
+	switch opts.Strategy {
+	case "server-side":
+		args = append(args, "--server-side")
+		if opts.ForceConflicts {
+			args = append(args, "--force-conflicts")
+		}
+		args = append(args, "--field-manager", fieldManagerOrDefault(opts.FieldManager))
+	default:
+		if opts.FieldManager != "" {
+			args = append(args, "--field-manager", opts.FieldManager)
+		}
+	}
+
+	return k.kubectl(data, args...)
+}
+
+// DiffServerSide runs `kubectl diff`, which performs a server-side dry-run
+// apply and returns the resulting diff
+func (k Client) DiffServerSide(data manifest.List) (*string, error) {
+	return k.diff(data, "diff")
+}
+
+// DiffServerSideApply runs `kubectl diff --server-side`, driving the diff off
+// a real Server-Side Apply dry-run instead of the legacy merge logic.
+// fieldManager and forceConflicts are threaded through so the dry-run
+// reflects the same identity/conflict handling Apply would actually use
+func (k Client) DiffServerSideApply(data manifest.List, fieldManager string, forceConflicts bool) (*string, error) {
+	args := []string{"diff", "--server-side", "--field-manager", fieldManagerOrDefault(fieldManager)}
+	if forceConflicts {
+		args = append(args, "--force-conflicts")
+	}
+	return k.diff(data, args...)
+}
+
+// GetByLabels returns all objects of `kind` in `namespace` that carry the
+// given labels, equivalent to `kubectl get <kind> -l <selector> -o json`
+func (k Client) GetByLabels(namespace, kind string, labels map[string]string) (manifest.List, error) {
+	args := []string{"get", kind, "-o", "json", "-l", labelSelector(labels)}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	} else {
+		args = append(args, "--all-namespaces")
+	}
+
+	out, stderr, err := k.run(args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing %s by label: %s", kind, stderr)
+	}
+
+	var list struct {
+		Items manifest.List `json:"items"`
+	}
+	if err := json.Unmarshal(out, &list); err != nil {
+		return nil, errors.Wrapf(err, "unmarshaling %s list", kind)
+	}
+	return list.Items, nil
+}
+
+// labelSelector turns a label map into the `k=v,k2=v2` form kubectl's -l
+// flag expects. Keys are sorted so the generated command line, and thus
+// test expectations, are stable
+func labelSelector(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// NotFoundError is returned by Get when the requested object does not exist
+type NotFoundError struct {
+	Namespace, Kind, Name string
+}
+
+func (e NotFoundError) Error() string {
+	return fmt.Sprintf("%s/%s not found in namespace %s", e.Kind, e.Name, e.Namespace)
+}
+
+// IsNotFound reports whether err is a NotFoundError
+func IsNotFound(err error) bool {
+	_, ok := errors.Cause(err).(NotFoundError)
+	return ok
+}
+
+// Get returns the live object of the given kind/name from the cluster,
+// equivalent to `kubectl get <kind> <name> -o json`. It returns a
+// NotFoundError when the object does not exist
+func (k Client) Get(namespace, kind, name string) (manifest.Manifest, error) {
+	args := []string{"get", kind, name, "-o", "json"}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+
+	out, stderr, err := k.run(args...)
+	if err != nil {
+		if bytes.Contains(stderr, []byte("NotFound")) {
+			return nil, NotFoundError{Namespace: namespace, Kind: kind, Name: name}
+		}
+		return nil, errors.Wrapf(err, "getting %s/%s: %s", kind, name, stderr)
+	}
+
+	var m manifest.Manifest
+	if err := json.Unmarshal(out, &m); err != nil {
+		return nil, errors.Wrapf(err, "unmarshaling %s/%s", kind, name)
+	}
+	return m, nil
+}
+
+// Delete removes every object in data from the cluster, equivalent to
+// `kubectl delete -f -`
+func (k Client) Delete(data manifest.List) error {
+	return k.kubectl(data, "delete", "-f", "-", "--ignore-not-found")
+}
+
+// Identifier is an alias of manifest.Identifier; the inventory ConfigMap
+// stores a JSON list of them
+type Identifier = manifest.Identifier
+
+// inventoryDataKey is the ConfigMap data key the inventory's serialized
+// identifier list is stored under
+const inventoryDataKey = "inventory.json"
+
+// GetInventory reads back the last-applied object identifiers for the given
+// environment from the `name` ConfigMap in `namespace`. It returns a
+// NotFoundError when no inventory has been persisted yet
+func (k Client) GetInventory(namespace, name string) ([]Identifier, error) {
+	cm, err := k.Get(namespace, "ConfigMap", name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := cm["data"].(map[string]interface{})
+	raw, ok := data[inventoryDataKey].(string)
+	if !ok {
+		return nil, NotFoundError{Namespace: namespace, Kind: "ConfigMap", Name: name}
+	}
+
+	var ids []Identifier
+	if err := json.Unmarshal([]byte(raw), &ids); err != nil {
+		return nil, errors.Wrapf(err, "unmarshaling inventory from %s/%s", namespace, name)
+	}
+	return ids, nil
+}
+
+// SaveInventory persists ids as the `name` ConfigMap in `namespace`,
+// creating or updating it as needed
+func (k Client) SaveInventory(namespace, name string, ids []Identifier) error {
+	raw, err := json.Marshal(ids)
+	if err != nil {
+		return errors.Wrap(err, "marshaling inventory")
+	}
+
+	cm := manifest.Manifest{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+		},
+		"data": map[string]interface{}{
+			inventoryDataKey: string(raw),
+		},
+	}
+
+	return k.kubectl(manifest.List{cm}, "apply", "-f", "-")
+}
+
+// APIResource describes a kind the apiserver knows about, as reported by
+// `kubectl api-resources`
+type APIResource struct {
+	Name       string
+	Kind       string
+	Group      string
+	Namespaced bool
+	Verbs      []string
+}
+
+// Listable reports whether objects of this kind can be listed, which is a
+// precondition for scanning it during orphan detection
+func (r APIResource) Listable() bool {
+	for _, v := range r.Verbs {
+		if v == "list" {
+			return true
+		}
+	}
+	return false
+}
+
+// APIResources returns every kind known to the apiserver, equivalent to
+// `kubectl api-resources -o wide --no-headers`
+func (k Client) APIResources() ([]APIResource, error) {
+	out, stderr, err := k.run("api-resources", "-o", "wide", "--no-headers")
+	if err != nil {
+		return nil, errors.Wrapf(err, "discovering api-resources: %s", stderr)
+	}
+	return parseAPIResources(out), nil
+}
+
+// parseAPIResources parses the columnar output of
+// `kubectl api-resources -o wide --no-headers`: NAME, an optional
+// SHORTNAMES, APIVERSION, NAMESPACED, KIND, VERBS and an optional
+// CATEGORIES. SHORTNAMES/CATEGORIES can be empty, so the fields actually
+// needed are located relative to VERBS - the first bracketed field - rather
+// than by a fixed index
+func parseAPIResources(out []byte) []APIResource {
+	resources := []APIResource{}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+
+		verbsIdx := -1
+		for i, f := range fields {
+			if strings.HasPrefix(f, "[") {
+				verbsIdx = i
+				break
+			}
+		}
+		if verbsIdx < 3 {
+			continue
+		}
+
+		// VERBS itself can contain spaces (e.g. "[create delete get]"); find
+		// where the bracketed group ends so a trailing CATEGORIES column
+		// doesn't get swept in as a verb
+		verbsEndIdx := verbsIdx
+		for verbsEndIdx < len(fields) && !strings.HasSuffix(fields[verbsEndIdx], "]") {
+			verbsEndIdx++
+		}
+		if verbsEndIdx >= len(fields) {
+			continue
+		}
+
+		resources = append(resources, APIResource{
+			Name:       fields[0],
+			Kind:       fields[verbsIdx-1],
+			Group:      apiGroup(fields[verbsIdx-3]),
+			Namespaced: fields[verbsIdx-2] == "true",
+			Verbs:      strings.Fields(strings.Trim(strings.Join(fields[verbsIdx:verbsEndIdx+1], " "), "[]")),
+		})
+	}
+
+	return resources
+}
+
+// apiGroup extracts the group from an APIVERSION column like "apps/v1",
+// returning "" for the core group (e.g. plain "v1")
+func apiGroup(apiVersion string) string {
+	if i := strings.Index(apiVersion, "/"); i >= 0 {
+		return apiVersion[:i]
+	}
+	return ""
+}
+
+// diff runs `kubectl <args> -f -` against the given manifests. kubectl diff
+// exits 1 to report "there are differences", which is not a failure; any
+// other non-zero exit is
+func (k Client) diff(data manifest.List, args ...string) (*string, error) {
+	stdin, err := manifestListReader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	fullArgs := append(append([]string{"--server", k.APIServer}, args...), "-f", "-")
+	cmd := exec.Command("kubectl", fullArgs...)
+	cmd.Stdin = stdin
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	runErr := cmd.Run()
+	exitErr, isExitErr := runErr.(*exec.ExitError)
+	switch {
+	case runErr == nil:
+		return nil, nil
+	case isExitErr && exitErr.ExitCode() == 1:
+		s := stdout.String()
+		return &s, nil
+	default:
+		return nil, errors.Wrapf(runErr, "running kubectl diff: %s", stderr.String())
+	}
+}
+
+// kubectl runs `kubectl <args>` with data piped to stdin as a `kind: List`
+// document. Unlike diff, it does not append `-f -`; callers pass it in args
+func (k Client) kubectl(data manifest.List, args ...string) error {
+	stdin, err := manifestListReader(data)
+	if err != nil {
+		return err
+	}
+
+	fullArgs := append([]string{"--server", k.APIServer}, args...)
+	cmd := exec.Command("kubectl", fullArgs...)
+	cmd.Stdin = stdin
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "running kubectl %s: %s", strings.Join(args, " "), stderr.String())
+	}
+	return nil
+}
+
+// run executes `kubectl <args>` against k.APIServer and returns stdout and
+// stderr separately, so callers like Get can tell a real failure apart from
+// a NotFound response without string-matching the wrapped error
+func (k Client) run(args ...string) (stdout, stderr []byte, err error) {
+	fullArgs := append([]string{"--server", k.APIServer}, args...)
+	cmd := exec.Command("kubectl", fullArgs...)
+
+	var out, errOut bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	err = cmd.Run()
+	return out.Bytes(), errOut.Bytes(), err
+}
+
+// manifestListReader serializes data as a `kind: List` JSON document, so a
+// single `kubectl ... -f -` invocation can apply/diff more than one object
+// at once
+func manifestListReader(data manifest.List) (*bytes.Reader, error) {
+	body, err := json.Marshal(struct {
+		APIVersion string        `json:"apiVersion"`
+		Kind       string        `json:"kind"`
+		Items      manifest.List `json:"items"`
+	}{
+		APIVersion: "v1",
+		Kind:       "List",
+		Items:      data,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "marshaling manifests")
+	}
+	return bytes.NewReader(body), nil
+}
+
+func fieldManagerOrDefault(name string) string {
+	if name == "" {
+		return "tanka"
+	}
+	return name
+}
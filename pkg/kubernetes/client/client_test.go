@@ -0,0 +1,54 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldManagerOrDefault(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"empty falls back to tanka", "", "tanka"},
+		{"explicit name is kept", "my-manager", "my-manager"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := fieldManagerOrDefault(c.in); got != c.want {
+				t.Errorf("fieldManagerOrDefault(%q) = %q, want %q", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestLabelSelector(t *testing.T) {
+	got := labelSelector(map[string]string{"tanka.dev/environment": "default/app", "app": "foo"})
+	want := "app=foo,tanka.dev/environment=default/app"
+	if got != want {
+		t.Errorf("labelSelector() = %q, want %q", got, want)
+	}
+}
+
+func TestParseAPIResources(t *testing.T) {
+	// a representative slice of real `kubectl api-resources -o wide
+	// --no-headers` output: SHORTNAMES is present for Pod/Deployment, empty
+	// for TokenReview, and CATEGORIES trails some but not all lines
+	out := []byte(`pods                  po           v1                             true         Pod          [create delete get list patch update watch]   all
+deployments           deploy       apps/v1                        true         Deployment   [create delete get list patch update watch]     all
+tokenreviews                       authentication.k8s.io/v1       false        TokenReview  [create]
+`)
+
+	got := parseAPIResources(out)
+	want := []APIResource{
+		{Name: "pods", Kind: "Pod", Group: "", Namespaced: true, Verbs: []string{"create", "delete", "get", "list", "patch", "update", "watch"}},
+		{Name: "deployments", Kind: "Deployment", Group: "apps", Namespaced: true, Verbs: []string{"create", "delete", "get", "list", "patch", "update", "watch"}},
+		{Name: "tokenreviews", Kind: "TokenReview", Group: "authentication.k8s.io", Namespaced: false, Verbs: []string{"create"}},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAPIResources() = %+v, want %+v", got, want)
+	}
+}
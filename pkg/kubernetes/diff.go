@@ -0,0 +1,115 @@
+package kubernetes
+
+import (
+	"encoding/json"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/kubernetes/util"
+)
+
+// ServerSideApplyDiffer runs a real Server-Side Apply dry-run against the
+// cluster, closing over the field-manager/force-conflicts identity so the
+// dry-run reflects what Apply would actually do. Built per-call in Diff,
+// since those come from DiffOpts rather than being fixed at New() time
+func ServerSideApplyDiffer(ctl client.Interface, fieldManager string, forceConflicts bool) Differ {
+	return func(state manifest.List) (*string, error) {
+		return ctl.DiffServerSideApply(state, fieldManager, forceConflicts)
+	}
+}
+
+// SubsetDiffer compares state against the cluster like ReadOnlyDiffer, but
+// only on the fields present in the desired manifest: it's meant for
+// clusters too old to run `kubectl diff`'s server-side dry-run (pre 1.13), where a
+// full comparison would be swamped by server-side defaulting this package
+// doesn't otherwise know about. Registered in Kubernetes.differs as "subset"
+func SubsetDiffer(ctl client.Interface) Differ {
+	return func(state manifest.List) (*string, error) {
+		diffs := ""
+		for _, desired := range state {
+			d, err := diffOneSubset(ctl, desired)
+			if err != nil {
+				return nil, err
+			}
+			diffs += d
+		}
+
+		if diffs == "" {
+			return nil, nil
+		}
+		return &diffs, nil
+	}
+}
+
+// diffOneSubset fetches the live object, strips the same server-populated
+// fields ReadOnlyDiffer strips, and then narrows it down to only the keys
+// present in desired before diffing, so fields Tanka never set don't show up
+// as noise
+func diffOneSubset(ctl client.Interface, desired manifest.Manifest) (string, error) {
+	return diffOneLive(ctl, desired, func(live manifest.Manifest) manifest.Manifest {
+		return subset(live, desired)
+	})
+}
+
+// diffOneLive fetches the live object, strips server-populated fields, and
+// renders a unified diff against desired. narrow, if non-nil, is applied to
+// the stripped live object before diffing - SubsetDiffer uses it to cut live
+// down to only the keys desired also has; ReadOnlyDiffer passes nil to
+// compare the whole object
+func diffOneLive(ctl client.Interface, desired manifest.Manifest, narrow func(manifest.Manifest) manifest.Manifest) (string, error) {
+	live, err := ctl.Get(desired.Metadata().Namespace(), desired.Kind(), desired.Metadata().Name())
+	switch {
+	case client.IsNotFound(err):
+		live = manifest.Manifest{}
+	case err != nil:
+		return "", err
+	default:
+		live = util.StripServerFields(live)
+		if narrow != nil {
+			live = narrow(live)
+		}
+	}
+
+	liveJSON, err := json.MarshalIndent(map[string]interface{}(live), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	desiredJSON, err := json.MarshalIndent(map[string]interface{}(desired), "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	name := objectspec(desired)
+	diff := difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(liveJSON)),
+		B:        difflib.SplitLines(string(desiredJSON)),
+		FromFile: name,
+		ToFile:   name,
+		Context:  3,
+	}
+
+	return difflib.GetUnifiedDiffString(diff)
+}
+
+// subset returns the entries of live whose keys also appear in desired,
+// recursing into nested maps so only the fields Tanka actually manages are
+// compared
+func subset(live, desired map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(desired))
+	for key, desiredVal := range desired {
+		liveVal, ok := live[key]
+		if !ok {
+			continue
+		}
+		liveMap, liveIsMap := liveVal.(map[string]interface{})
+		desiredMap, desiredIsMap := desiredVal.(map[string]interface{})
+		if liveIsMap && desiredIsMap {
+			out[key] = subset(liveMap, desiredMap)
+			continue
+		}
+		out[key] = liveVal
+	}
+	return out
+}
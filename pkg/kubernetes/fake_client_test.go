@@ -0,0 +1,107 @@
+package kubernetes
+
+import (
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+// fakeClient is a minimal client.Interface implementation that records the
+// calls made to it, so Kubernetes's orchestration (Apply, Prune, ...) can be
+// exercised without shelling out to kubectl
+type fakeClient struct {
+	info    *client.Info
+	infoErr error
+
+	applyCalls []client.ApplyOpts
+	applyErr   error
+
+	getByLabelsResult manifest.List
+	getByLabelsErr    error
+
+	// getResults lets a test make Get return a specific live object for a
+	// given identifier, e.g. one still on the cluster under its old name.
+	// Identifiers not present here fall back to NotFoundError
+	getResults map[manifest.Identifier]manifest.Manifest
+	getErr     error
+
+	deleteCalls int
+	deletedData manifest.List
+	deleteErr   error
+
+	inventory          []client.Identifier
+	inventoryErr       error
+	saveInventoryCalls int
+	saveInventoryErr   error
+
+	apiResources []client.APIResource
+}
+
+var _ client.Interface = (*fakeClient)(nil)
+
+func (f *fakeClient) Info() (*client.Info, error) {
+	if f.infoErr != nil {
+		return nil, f.infoErr
+	}
+	if f.info == nil {
+		return &client.Info{}, nil
+	}
+	return f.info, nil
+}
+
+func (f *fakeClient) Apply(data manifest.List, opts client.ApplyOpts) error {
+	f.applyCalls = append(f.applyCalls, opts)
+	return f.applyErr
+}
+
+func (f *fakeClient) DiffServerSide(data manifest.List) (*string, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) DiffServerSideApply(data manifest.List, fieldManager string, forceConflicts bool) (*string, error) {
+	return nil, nil
+}
+
+func (f *fakeClient) GetByLabels(namespace, kind string, labels map[string]string) (manifest.List, error) {
+	return f.getByLabelsResult, f.getByLabelsErr
+}
+
+func (f *fakeClient) Get(namespace, kind, name string) (manifest.Manifest, error) {
+	if f.getErr != nil {
+		return nil, f.getErr
+	}
+	id := manifest.Identifier{Namespace: namespace, Kind: kind, Name: name}
+	if m, ok := f.getResults[id]; ok {
+		return m, nil
+	}
+	return nil, client.NotFoundError{Namespace: namespace, Kind: kind, Name: name}
+}
+
+func (f *fakeClient) Delete(data manifest.List) error {
+	f.deleteCalls++
+	f.deletedData = data
+	return f.deleteErr
+}
+
+func (f *fakeClient) GetInventory(namespace, name string) ([]client.Identifier, error) {
+	if f.inventoryErr != nil {
+		return nil, f.inventoryErr
+	}
+	return f.inventory, nil
+}
+
+// SaveInventory persists into the same f.inventory field GetInventory reads
+// back, so a test driving two sequential Apply calls sees the second one's
+// Prune observe what the first one actually saved - exactly what a real
+// ConfigMap-backed client would do
+func (f *fakeClient) SaveInventory(namespace, name string, ids []client.Identifier) error {
+	f.saveInventoryCalls++
+	if f.saveInventoryErr != nil {
+		return f.saveInventoryErr
+	}
+	f.inventory = ids
+	return nil
+}
+
+func (f *fakeClient) APIResources() ([]client.APIResource, error) {
+	return f.apiResources, nil
+}
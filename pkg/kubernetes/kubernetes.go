@@ -2,6 +2,8 @@ package kubernetes
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/Masterminds/semver"
 	"github.com/fatih/color"
@@ -11,19 +13,36 @@ import (
 	"github.com/grafana/tanka/pkg/kubernetes/client"
 	"github.com/grafana/tanka/pkg/kubernetes/manifest"
 	"github.com/grafana/tanka/pkg/kubernetes/util"
+	"github.com/grafana/tanka/pkg/kubernetes/wait"
 	"github.com/grafana/tanka/pkg/spec/v1alpha1"
 )
 
+// orphanResourceCacheTTL is how long the discovered API resources are cached
+// on the Kubernetes struct, so that repeated apply/diff calls within the
+// same process don't re-hit `/apis`
+const orphanResourceCacheTTL = 5 * time.Minute
+
+// orphanWorkerPoolSize bounds how many `kubectl get` calls run concurrently
+// during orphan detection, so clusters with hundreds of CRDs don't get
+// hammered with one goroutine per kind
+const orphanWorkerPoolSize = 10
+
 // Kubernetes exposes methods to work with the Kubernetes orchestrator
 type Kubernetes struct {
 	Env v1alpha1.Config
 
 	// Client (kubectl)
-	ctl  client.Client
+	ctl  client.Interface
 	info client.Info
 
 	// Diffing
 	differs map[string]Differ // List of diff strategies
+
+	// apiResources caches the discovered API resources used for orphan
+	// scanning, along with when they were fetched
+	apiResourcesMu  sync.Mutex
+	apiResources    []client.APIResource
+	apiResourcesAge time.Time
 }
 
 // Differ is responsible for comparing the given manifests to the cluster and
@@ -46,62 +65,114 @@ func New(c v1alpha1.Config) (*Kubernetes, error) {
 
 	// setup diffing
 	if c.Spec.DiffStrategy == "" {
-		c.Spec.DiffStrategy = "native"
-
-		if info.ServerVersion.LessThan(semver.MustParse("1.13.0")) {
-			c.Spec.DiffStrategy = "subset"
-		}
+		c.Spec.DiffStrategy = defaultDiffStrategy(info)
 	}
 
 	k := Kubernetes{
 		Env:  c,
 		ctl:  ctl,
 		info: *info,
+		// "server-side" is built per-call in Diff instead of here, since its
+		// field-manager/force-conflicts come from DiffOpts
 		differs: map[string]Differ{
-			"native": ctl.DiffServerSide,
-			"subset": SubsetDiffer(ctl),
+			"native":    ctl.DiffServerSide,
+			"subset":    SubsetDiffer(ctl),
+			"read-only": ReadOnlyDiffer(ctl),
 		},
 	}
 
 	return &k, nil
 }
 
+// defaultDiffStrategy picks the DiffStrategy to use when the environment
+// doesn't set one explicitly, based on the apiserver's version: "subset"
+// below 1.13 (too old for `kubectl diff`'s server-side dry-run), "server-side" at or
+// above 1.18 (Server-Side Apply is stable there), "native" in between.
+// Pulled out of New() so the version-gating can be unit tested without a
+// live client
+func defaultDiffStrategy(info *client.Info) string {
+	switch {
+	case info.ServerVersion.LessThan(semver.MustParse("1.13.0")):
+		return "subset"
+	case !info.ServerVersion.LessThan(semver.MustParse("1.18.0")):
+		return "server-side"
+	default:
+		return "native"
+	}
+}
+
 // ApplyOpts allow set additional parameters for the apply operation
-type ApplyOpts client.ApplyOpts
+type ApplyOpts struct {
+	client.ApplyOpts
+
+	// Wait blocks until the applied state is Ready, as reported by
+	// per-kind readiness checks
+	Wait bool
+	// WaitTimeout bounds how long Wait polls for before giving up. Zero
+	// means no deadline
+	WaitTimeout time.Duration
+
+	// Prune, if set, deletes orphaned objects after a successful apply
+	Prune *PruneOpts
+}
 
 // Apply receives a state object generated using `Reconcile()` and may apply it to the target system
 func (k *Kubernetes) Apply(state manifest.List, opts ApplyOpts) error {
-	if false {
-		info, err := k.ctl.Info()
-		if err != nil {
+	info, err := k.ctl.Info()
+	if err != nil {
+		return err
+	}
+	alert := color.New(color.FgRed, color.Bold).SprintFunc()
+
+	if !opts.AutoApprove {
+		if err := cli.Confirm(
+			fmt.Sprintf(`Applying to namespace '%s' of cluster '%s' at '%s' using context '%s'.`,
+				alert(k.Env.Spec.Namespace),
+				alert(info.Cluster.Get("name").String()),
+				alert(info.Cluster.Get("cluster.server").String()),
+				alert(info.Context.Get("name").String()),
+			),
+			"yes",
+		); err != nil {
 			return err
 		}
-		alert := color.New(color.FgRed, color.Bold).SprintFunc()
-
-		if !opts.AutoApprove {
-			if err := cli.Confirm(
-				fmt.Sprintf(`Applying to namespace '%s' of cluster '%s' at '%s' using context '%s'.`,
-					alert(k.Env.Spec.Namespace),
-					alert(info.Cluster.Get("name").MustStr()),
-					alert(info.Cluster.Get("cluster.server").MustStr()),
-					alert(info.Context.Get("name").MustStr()),
-				),
-				"yes",
-			); err != nil {
-				return err
-			}
-		}
-		return k.ctl.Apply(state, client.ApplyOpts(opts))
 	}
 
-	list, err := k.listOrphaned(state)
-	if err != nil {
+	applyOpts := opts.ApplyOpts
+	if applyOpts.Strategy == "" {
+		applyOpts.Strategy = k.Env.Spec.ApplyStrategy
+	}
+	if applyOpts.FieldManager == "" {
+		applyOpts.FieldManager = k.Env.Spec.FieldManager
+	}
+	if !applyOpts.ForceConflicts {
+		applyOpts.ForceConflicts = k.Env.Spec.ForceConflicts
+	}
+	if err := k.ctl.Apply(state, applyOpts); err != nil {
 		return err
 	}
 
-	fmt.Println("orphan")
-	for _, m := range list {
-		fmt.Println(m.Identifier())
+	// Prune runs, and the inventory is persisted, off the success of Apply
+	// itself rather than Wait's outcome: a rollout that's merely slow to
+	// become ready shouldn't leave a successful apply unrecorded and orphan
+	// detection permanently stalled. Prune must run before saveInventory:
+	// it diffs state against the *previous* inventory to catch renamed
+	// objects, so overwriting that inventory first would make every entry
+	// in it identical to state and the rename-tracking fallback a no-op
+	if opts.Prune != nil {
+		if _, err := k.Prune(state, *opts.Prune); err != nil {
+			return err
+		}
+	}
+
+	if err := k.saveInventory(state); err != nil {
+		return errors.Wrap(err, "persisting inventory")
+	}
+
+	if opts.Wait {
+		if err := k.Wait(state, wait.Opts{Timeout: opts.WaitTimeout}); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -114,6 +185,19 @@ type DiffOpts struct {
 
 	// Set the diff-strategy. If unset, the value set in the spec is used
 	Strategy string
+
+	// ReadOnly forces the "read-only" strategy, which compares against the
+	// cluster using GET only, for users without patch/update RBAC
+	ReadOnly bool
+
+	// FieldManager used when diffing with the "server-side" strategy.
+	// Defaults to the spec's FieldManager, like Apply
+	FieldManager string
+
+	// ForceConflicts instructs the "server-side" strategy's dry-run to take
+	// ownership of fields already managed by another field manager, like
+	// Apply. Defaults to the spec's ForceConflicts
+	ForceConflicts bool
 }
 
 // Diff takes the desired state and returns the differences from the cluster
@@ -122,8 +206,21 @@ func (k *Kubernetes) Diff(state manifest.List, opts DiffOpts) (*string, error) {
 	if opts.Strategy != "" {
 		strategy = opts.Strategy
 	}
+	if opts.ReadOnly {
+		strategy = "read-only"
+	}
+
+	differ := k.differs[strategy]
+	if strategy == "server-side" {
+		fieldManager := opts.FieldManager
+		if fieldManager == "" {
+			fieldManager = k.Env.Spec.FieldManager
+		}
+		forceConflicts := opts.ForceConflicts || k.Env.Spec.ForceConflicts
+		differ = ServerSideApplyDiffer(k.ctl, fieldManager, forceConflicts)
+	}
 
-	d, err := k.differs[strategy](state)
+	d, err := differ(state)
 	switch {
 	case err != nil:
 		return nil, err
@@ -143,6 +240,12 @@ func (k *Kubernetes) Info() client.Info {
 	return k.info
 }
 
+// Wait blocks until every object in state reports itself ready, or until
+// opts.Timeout elapses
+func (k *Kubernetes) Wait(state manifest.List, opts wait.Opts) error {
+	return wait.Wait(k.ctl, state, opts)
+}
+
 func objectspec(m manifest.Manifest) string {
 	return fmt.Sprintf("%s/%s",
 		m.Kind(),
@@ -151,92 +254,201 @@ func objectspec(m manifest.Manifest) string {
 }
 
 // listOrphaned returns all resources known to the cluster not present in
-// Jsonnet
-func (k *Kubernetes) listOrphaned(state manifest.List) (manifest.List, error) {
+// Jsonnet. extraSelector, if non-empty, further restricts the scan to
+// objects also matching that label selector (e.g. from PruneOpts.Selector)
+func (k *Kubernetes) listOrphaned(state manifest.List, extraSelector string) (manifest.List, error) {
 	known := make(map[manifest.Identifier]bool)
 	for _, m := range state {
 		known[m.Identifier()] = true
 	}
-	fmt.Println(known)
 
-	fmt.Println("----")
+	// the persisted inventory remembers what Tanka applied last time,
+	// independent of whether the live object still carries the
+	// environment label, so a renamed-away-from object is still found
+	inventory, err := k.loadInventory()
+	if err != nil {
+		return nil, errors.Wrap(err, "loading inventory")
+	}
 
-	// https://github.com/kubernetes/kubectl/blob/b909fcb4a071a1a9669a9fe1f48482c848823124/pkg/cmd/apply/apply.go#L671-L688
-	kinds := []string{
-		// core
-		"ConfigMap",
-		"Endpoints",
-		"Namespace",
-		"PersistentVolumeClaim",
-		"PersistentVolume",
-		"Pod",
-		"ReplicationController",
-		"Secret",
-		"ServiceAccount",
-		"Service",
+	extra, err := parseSelector(extraSelector)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing selector")
+	}
 
-		"DaemonSet",
-		"Deployment",
-		"ReplicaSet",
-		"StatefulSet",
+	resources, err := k.discoverAPIResources()
+	if err != nil {
+		return nil, errors.Wrap(err, "discovering apiResources")
+	}
 
-		"Job",
-		"CronJob",
+	kinds := orphanScanKinds(resources, k.Env.Spec.OrphanResources)
 
-		"Ingress",
+	orphaned := manifest.List{}
 
-		"ClusterRole",
-		"ClusterRoleBinding",
-		"Role",
-		"RoleBinding",
+	type result struct {
+		list manifest.List
+		err  error
 	}
 
-	// var err error
-	// kinds, err = k.ctl.APIResources()
-	// if err != nil {
-	// 	return nil, errors.Wrap(err, "listing apiResources")
-	// }
+	jobs := make(chan scanKind)
+	results := make(chan result)
 
-	orphaned := manifest.List{}
+	var wg sync.WaitGroup
+	poolSize := orphanWorkerPoolSize
+	if poolSize > len(kinds) {
+		poolSize = len(kinds)
+	}
+	for i := 0; i < poolSize; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for kind := range jobs {
+				labels := map[string]string{
+					LabelEnvironment: k.Env.Metadata.NameLabel(),
+				}
+				for key, value := range extra {
+					labels[key] = value
+				}
+				// cluster-scoped kinds (e.g. Namespace, ClusterRole) have no
+				// namespace to scan within; only namespaced kinds are
+				// restricted to the environment's namespace
+				namespace := ""
+				if kind.Namespaced {
+					namespace = k.Env.Spec.Namespace
+				}
+				list, err := k.ctl.GetByLabels(namespace, kind.Kind, labels)
+				if err != nil {
+					err = errors.Wrapf(err, "getting orphans of kind '%s':", kind.Kind)
+				}
+				results <- result{list: list, err: err}
+			}
+		}()
+	}
 
-	r := make(chan (manifest.List))
-	e := make(chan (error))
+	go func() {
+		for _, kind := range kinds {
+			jobs <- kind
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
 
-	for _, kind := range kinds {
-		go k.parallelGetByLabels(kind, k.Env.Metadata.NameLabel(), r, e)
-	}
+	found := make(map[manifest.Identifier]bool)
 
 	var lastErr error
-	for i := 0; i < len(kinds); i++ {
-		select {
-		case list := <-r:
-			for _, m := range list {
-				fmt.Println(m.Identifier())
-				if known[m.Identifier()] {
-					continue
-				}
-				orphaned = append(orphaned, m)
+	for res := range results {
+		if res.err != nil {
+			lastErr = res.err
+			continue
+		}
+		for _, m := range res.list {
+			found[m.Identifier()] = true
+			if known[m.Identifier()] || !prunable(m) {
+				continue
 			}
-		case err := <-e:
-			lastErr = err
+			orphaned = append(orphaned, m)
 		}
 	}
-	close(r)
-	close(e)
 
 	if lastErr != nil {
 		return nil, lastErr
 	}
 
+	// the label scan may miss objects the inventory still remembers (e.g.
+	// a label got edited away); fall back to a direct Get for those
+	for id := range inventory {
+		if known[id] || found[id] {
+			continue
+		}
+		m, err := k.ctl.Get(id.Namespace, id.Kind, id.Name)
+		if client.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "getting inventory object '%s/%s'", id.Kind, id.Name)
+		}
+		if prunable(m) && matchesExtra(m, extra) {
+			orphaned = append(orphaned, m)
+		}
+	}
+
 	return orphaned, nil
 }
 
-func (k *Kubernetes) parallelGetByLabels(kind, envName string, r chan (manifest.List), e chan (error)) {
-	list, err := k.ctl.GetByLabels("", kind, map[string]string{
-		LabelEnvironment: envName,
-	})
+// discoverAPIResources returns every API resource the apiserver knows about
+// (orphanScanKinds drops the non-listable ones), caching the result for orphanResourceCacheTTL so repeated
+// apply/diff invocations don't re-hit /apis
+func (k *Kubernetes) discoverAPIResources() ([]client.APIResource, error) {
+	k.apiResourcesMu.Lock()
+	defer k.apiResourcesMu.Unlock()
+
+	if k.apiResources != nil && time.Since(k.apiResourcesAge) < orphanResourceCacheTTL {
+		return k.apiResources, nil
+	}
+
+	resources, err := k.ctl.APIResources()
 	if err != nil {
-		e <- errors.Wrapf(err, "getting orphans of kind '%s':", kind)
+		return nil, err
+	}
+
+	k.apiResources = resources
+	k.apiResourcesAge = time.Now()
+	return resources, nil
+}
+
+// scanKind is a kind to scan for orphans, along with whether it is
+// namespaced, so the scan can be restricted to the environment's namespace
+// where that makes sense
+type scanKind struct {
+	Kind       string
+	Namespaced bool
+}
+
+// orphanScanKinds turns the discovered API resources into the deduplicated
+// list of kinds to scan for orphans, honoring the include/exclude filter and
+// dropping non-listable resources
+func orphanScanKinds(resources []client.APIResource, filter v1alpha1.ResourceFilter) []scanKind {
+	seen := make(map[string]bool)
+	kinds := []scanKind{}
+
+	for _, r := range resources {
+		if !r.Listable() {
+			continue
+		}
+		if !resourceFilterAllows(r, filter) {
+			continue
+		}
+		if seen[r.Kind] {
+			continue
+		}
+		seen[r.Kind] = true
+		kinds = append(kinds, scanKind{Kind: r.Kind, Namespaced: r.Namespaced})
+	}
+
+	return kinds
+}
+
+func resourceFilterAllows(r client.APIResource, filter v1alpha1.ResourceFilter) bool {
+	if len(filter.IncludeGroups) > 0 && !contains(filter.IncludeGroups, r.Group) {
+		return false
+	}
+	if len(filter.IncludeKinds) > 0 && !contains(filter.IncludeKinds, r.Kind) {
+		return false
+	}
+	if contains(filter.ExcludeGroups, r.Group) {
+		return false
+	}
+	if contains(filter.ExcludeKinds, r.Kind) {
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
 	}
-	r <- list
+	return false
 }
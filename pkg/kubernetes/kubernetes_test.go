@@ -0,0 +1,76 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/Masterminds/semver"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/spec/v1alpha1"
+)
+
+func TestDefaultDiffStrategy(t *testing.T) {
+	cases := []struct {
+		version string
+		want    string
+	}{
+		{"1.12.9", "subset"},
+		{"1.13.0", "native"},
+		{"1.17.9", "native"},
+		{"1.18.0", "server-side"},
+		{"1.24.0", "server-side"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.version, func(t *testing.T) {
+			info := &client.Info{ServerVersion: semver.MustParse(c.version)}
+			if got := defaultDiffStrategy(info); got != c.want {
+				t.Errorf("defaultDiffStrategy(%s) = %q, want %q", c.version, got, c.want)
+			}
+		})
+	}
+}
+
+func TestOrphanScanKinds(t *testing.T) {
+	resources := []client.APIResource{
+		{Kind: "Pod", Group: "", Namespaced: true, Verbs: []string{"list", "get"}},
+		{Kind: "Namespace", Group: "", Namespaced: false, Verbs: []string{"list", "get"}},
+		{Kind: "TokenReview", Group: "authentication.k8s.io", Namespaced: false, Verbs: []string{"create"}},
+		{Kind: "Deployment", Group: "apps", Namespaced: true, Verbs: []string{"list", "get"}},
+	}
+
+	t.Run("drops non-listable and preserves namespaced-ness", func(t *testing.T) {
+		kinds := orphanScanKinds(resources, v1alpha1.ResourceFilter{})
+
+		byKind := make(map[string]scanKind, len(kinds))
+		for _, k := range kinds {
+			byKind[k.Kind] = k
+		}
+
+		if _, ok := byKind["TokenReview"]; ok {
+			t.Fatalf("expected non-listable TokenReview to be dropped, got %+v", kinds)
+		}
+		if pod, ok := byKind["Pod"]; !ok || !pod.Namespaced {
+			t.Errorf("expected Pod to be scanned as namespaced, got %+v", byKind["Pod"])
+		}
+		if ns, ok := byKind["Namespace"]; !ok || ns.Namespaced {
+			t.Errorf("expected Namespace to be scanned as cluster-scoped, got %+v", byKind["Namespace"])
+		}
+	})
+
+	t.Run("honors include filter", func(t *testing.T) {
+		kinds := orphanScanKinds(resources, v1alpha1.ResourceFilter{IncludeKinds: []string{"Deployment"}})
+		if len(kinds) != 1 || kinds[0].Kind != "Deployment" {
+			t.Errorf("expected only Deployment, got %+v", kinds)
+		}
+	})
+
+	t.Run("honors exclude filter", func(t *testing.T) {
+		kinds := orphanScanKinds(resources, v1alpha1.ResourceFilter{ExcludeKinds: []string{"Deployment"}})
+		for _, k := range kinds {
+			if k.Kind == "Deployment" {
+				t.Errorf("expected Deployment to be excluded, got %+v", kinds)
+			}
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package kubernetes
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+	"github.com/grafana/tanka/pkg/kubernetes/wait"
+)
+
+// pruneAnnotation, when set to "false" on a live object, excludes it from
+// orphan detection even when it carries the environment label
+const pruneAnnotation = "tanka.dev/prune"
+
+// defaultProtectedKinds is never auto-pruned, regardless of labels or
+// annotations, on top of whatever the caller adds via PruneOpts.ProtectedKinds
+var defaultProtectedKinds = []string{"PersistentVolume", "Namespace"}
+
+// PruneOpts configures which orphaned objects Prune considers safe to delete
+type PruneOpts struct {
+	// Selector further restricts the scan to objects also matching this
+	// label selector, e.g. "app=foo,tier=backend"
+	Selector string
+
+	// DryRun lists what would be pruned without deleting anything
+	DryRun bool
+
+	// ProtectedKinds is never auto-pruned, in addition to
+	// defaultProtectedKinds
+	ProtectedKinds []string
+
+	// WhiteList, if non-empty, restricts pruning to only these identifiers
+	WhiteList []manifest.Identifier
+
+	// BlackList excludes these identifiers from pruning
+	BlackList []manifest.Identifier
+
+	// Wait blocks Prune until the deleted objects are actually gone
+	Wait bool
+	// WaitTimeout bounds how long Wait polls for before giving up
+	WaitTimeout time.Duration
+}
+
+// Prune deletes objects found by listOrphaned, except those excluded by
+// opts. It does not persist the inventory itself - Apply does that once,
+// after Prune returns, so a prune triggered from Apply doesn't write the
+// inventory ConfigMap twice
+func (k *Kubernetes) Prune(state manifest.List, opts PruneOpts) (manifest.List, error) {
+	orphaned, err := k.listOrphaned(state, opts.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	toPrune := filterPrunable(orphaned, opts)
+
+	if opts.DryRun {
+		return toPrune, nil
+	}
+
+	if err := k.ctl.Delete(toPrune); err != nil {
+		return toPrune, errors.Wrap(err, "deleting orphaned objects")
+	}
+
+	if opts.Wait {
+		if err := wait.WaitDeleted(k.ctl, toPrune, wait.Opts{Timeout: opts.WaitTimeout}); err != nil {
+			return toPrune, err
+		}
+	}
+
+	return toPrune, nil
+}
+
+// prunable reports whether a live object is eligible for deletion, i.e. it
+// does not opt out via the tanka.dev/prune annotation
+func prunable(m manifest.Manifest) bool {
+	ann := m.Metadata().Annotations()
+	if ann == nil {
+		return true
+	}
+	return ann[pruneAnnotation] != "false"
+}
+
+// filterPrunable narrows an orphan list down using PruneOpts: protected
+// kinds, and the white/black list of identifiers
+func filterPrunable(list manifest.List, opts PruneOpts) manifest.List {
+	protected := append(append([]string{}, defaultProtectedKinds...), opts.ProtectedKinds...)
+
+	out := manifest.List{}
+	for _, m := range list {
+		id := m.Identifier()
+
+		if contains(protected, id.Kind) {
+			continue
+		}
+		if len(opts.WhiteList) > 0 && !identifierIn(opts.WhiteList, id) {
+			continue
+		}
+		if identifierIn(opts.BlackList, id) {
+			continue
+		}
+
+		out = append(out, m)
+	}
+
+	return out
+}
+
+func identifierIn(list []manifest.Identifier, id manifest.Identifier) bool {
+	for _, item := range list {
+		if item == id {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSelector turns a `k=v,k2=v2` label selector into a label map.
+// An empty string returns an empty, non-nil map
+func parseSelector(selector string) (map[string]string, error) {
+	labels := map[string]string{}
+	if selector == "" {
+		return labels, nil
+	}
+
+	for _, pair := range strings.Split(selector, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid selector fragment '%s', expected key=value", pair)
+		}
+		labels[kv[0]] = kv[1]
+	}
+
+	return labels, nil
+}
+
+// matchesExtra reports whether m carries every key/value pair in extra. The
+// label-based orphan scan already applies extra through GetByLabels; this
+// is for objects recovered through the inventory fallback instead, so
+// PruneOpts.Selector scopes those the same way
+func matchesExtra(m manifest.Manifest, extra map[string]string) bool {
+	labels := m.Metadata().Labels()
+	for key, value := range extra {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// inventoryConfigMapName is keyed by environment name, so multiple
+// environments applying into the same namespace don't clash
+func inventoryConfigMapName(envName string) string {
+	return "tanka." + envName + ".inventory"
+}
+
+// loadInventory returns the set of object identifiers Tanka applied the
+// last time it successfully ran against this environment, read back from
+// the inventory ConfigMap. A missing ConfigMap is not an error: this is
+// either the first apply, or an environment that predates inventory tracking
+func (k *Kubernetes) loadInventory() (map[manifest.Identifier]bool, error) {
+	ids, err := k.ctl.GetInventory(k.Env.Spec.Namespace, inventoryConfigMapName(k.Env.Metadata.Name))
+	if client.IsNotFound(err) {
+		return map[manifest.Identifier]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[manifest.Identifier]bool, len(ids))
+	for _, id := range ids {
+		known[id] = true
+	}
+	return known, nil
+}
+
+// saveInventory persists the identifiers of state as the new inventory, so
+// the next apply/prune cycle can find objects even if their labels change
+func (k *Kubernetes) saveInventory(state manifest.List) error {
+	ids := make([]manifest.Identifier, 0, len(state))
+	for _, m := range state {
+		ids = append(ids, m.Identifier())
+	}
+
+	return k.ctl.SaveInventory(k.Env.Spec.Namespace, inventoryConfigMapName(k.Env.Metadata.Name), ids)
+}
@@ -0,0 +1,112 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+func orphan(kind, name string) manifest.Manifest {
+	return manifest.Manifest{
+		"kind": kind,
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "default",
+		},
+	}
+}
+
+func TestFilterPrunable(t *testing.T) {
+	list := manifest.List{
+		orphan("ConfigMap", "foo"),
+		orphan("PersistentVolume", "pv-1"),
+		orphan("Secret", "bar"),
+	}
+
+	t.Run("drops default protected kinds", func(t *testing.T) {
+		out := filterPrunable(list, PruneOpts{})
+		for _, m := range out {
+			if m.Identifier().Kind == "PersistentVolume" {
+				t.Errorf("expected PersistentVolume to be protected by default, got %+v", out)
+			}
+		}
+	})
+
+	t.Run("honors caller-supplied ProtectedKinds on top of the default set", func(t *testing.T) {
+		out := filterPrunable(list, PruneOpts{ProtectedKinds: []string{"Secret"}})
+		for _, m := range out {
+			if m.Identifier().Kind == "Secret" || m.Identifier().Kind == "PersistentVolume" {
+				t.Errorf("expected Secret and PersistentVolume to be protected, got %+v", out)
+			}
+		}
+	})
+
+	t.Run("WhiteList restricts to only the listed identifiers", func(t *testing.T) {
+		out := filterPrunable(list, PruneOpts{
+			WhiteList: []manifest.Identifier{{Kind: "ConfigMap", Name: "foo", Namespace: "default"}},
+		})
+		if len(out) != 1 || out[0].Identifier().Kind != "ConfigMap" {
+			t.Errorf("expected only the whitelisted ConfigMap, got %+v", out)
+		}
+	})
+
+	t.Run("BlackList excludes the listed identifiers", func(t *testing.T) {
+		out := filterPrunable(list, PruneOpts{
+			BlackList: []manifest.Identifier{{Kind: "Secret", Name: "bar", Namespace: "default"}},
+		})
+		for _, m := range out {
+			if m.Identifier().Kind == "Secret" {
+				t.Errorf("expected blacklisted Secret to be excluded, got %+v", out)
+			}
+		}
+	})
+}
+
+func TestPrunable(t *testing.T) {
+	withoutAnnotation := orphan("ConfigMap", "foo")
+	if !prunable(withoutAnnotation) {
+		t.Errorf("expected an object without the opt-out annotation to be prunable")
+	}
+
+	optedOut := manifest.Manifest{
+		"kind": "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":        "foo",
+			"annotations": map[string]interface{}{pruneAnnotation: "false"},
+		},
+	}
+	if prunable(optedOut) {
+		t.Errorf("expected tanka.dev/prune=false to opt an object out of pruning")
+	}
+}
+
+func TestMatchesExtra(t *testing.T) {
+	labeled := manifest.Manifest{
+		"kind": "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":   "foo",
+			"labels": map[string]interface{}{"app": "foo", "tier": "backend"},
+		},
+	}
+
+	t.Run("empty selector matches everything", func(t *testing.T) {
+		if !matchesExtra(orphan("ConfigMap", "foo"), map[string]string{}) {
+			t.Errorf("expected an empty selector to match")
+		}
+	})
+
+	t.Run("matches when every pair is present", func(t *testing.T) {
+		if !matchesExtra(labeled, map[string]string{"app": "foo"}) {
+			t.Errorf("expected a subset of the object's labels to match")
+		}
+	})
+
+	t.Run("rejects when a pair is missing or differs", func(t *testing.T) {
+		if matchesExtra(labeled, map[string]string{"app": "bar"}) {
+			t.Errorf("expected a differing label value not to match")
+		}
+		if matchesExtra(orphan("ConfigMap", "foo"), map[string]string{"app": "foo"}) {
+			t.Errorf("expected an object without the label not to match")
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package kubernetes
+
+import (
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+// ReadOnlyDiffer compares state against the cluster using only GET calls, so
+// it works for users without the patch/update RBAC that `kubectl diff`
+// requires for its server-side dry-run. Registered in Kubernetes.differs as
+// "read-only"
+func ReadOnlyDiffer(ctl client.Interface) Differ {
+	return func(state manifest.List) (*string, error) {
+		diffs := ""
+		for _, desired := range state {
+			d, err := diffOneReadOnly(ctl, desired)
+			if err != nil {
+				return nil, err
+			}
+			diffs += d
+		}
+
+		if diffs == "" {
+			return nil, nil
+		}
+		return &diffs, nil
+	}
+}
+
+// diffOneReadOnly fetches the live object and diffs it against desired
+// without ever issuing a write. A missing object renders as a pure addition
+func diffOneReadOnly(ctl client.Interface, desired manifest.Manifest) (string, error) {
+	return diffOneLive(ctl, desired, nil)
+}
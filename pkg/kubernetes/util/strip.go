@@ -0,0 +1,80 @@
+package util
+
+import "github.com/grafana/tanka/pkg/kubernetes/manifest"
+
+// serverPopulatedFields are removed before comparing a live object against
+// the desired manifest, as the apiserver (or built-in defaulting) sets them
+// regardless of what was submitted
+var serverPopulatedFields = [][]string{
+	{"status"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "uid"},
+	{"metadata", "generation"},
+	{"metadata", "managedFields"},
+	{"metadata", "creationTimestamp"},
+}
+
+// specDefaultedFields are removed in addition to serverPopulatedFields, but
+// only for the kind they key on: these are fields the apiserver defaults
+// onto the spec itself (as opposed to status), so comparing them against a
+// manifest that never set them would otherwise show noise on every diff
+var specDefaultedFields = map[string][][]string{
+	"Service": {
+		{"spec", "clusterIP"},
+		{"spec", "clusterIPs"},
+	},
+	"Pod": {
+		{"spec", "nodeName"},
+		{"spec", "serviceAccount"},
+		{"spec", "dnsPolicy"},
+		{"spec", "schedulerName"},
+		{"spec", "securityContext"},
+		{"spec", "terminationGracePeriodSeconds"},
+	},
+}
+
+// StripServerFields returns a copy of m with server-populated fields
+// (status, resourceVersion, uid, generation, managedFields,
+// creationTimestamp, ...) removed, so it can be compared against a desired
+// manifest that never set them. For Service/Pod, the default-injected spec
+// fields in specDefaultedFields are stripped as well. Shared by the `subset`
+// and `read-only` diff strategies
+func StripServerFields(m manifest.Manifest) manifest.Manifest {
+	out := manifest.Manifest(deepCopy(map[string]interface{}(m)))
+	for _, path := range serverPopulatedFields {
+		deleteNested(out, path)
+	}
+	if kind, ok := out["kind"].(string); ok {
+		for _, path := range specDefaultedFields[kind] {
+			deleteNested(out, path)
+		}
+	}
+	return out
+}
+
+func deleteNested(m map[string]interface{}, path []string) {
+	if len(path) == 0 {
+		return
+	}
+	if len(path) == 1 {
+		delete(m, path[0])
+		return
+	}
+	next, ok := m[path[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	deleteNested(next, path[1:])
+}
+
+func deepCopy(v map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(v))
+	for k, val := range v {
+		if nested, ok := val.(map[string]interface{}); ok {
+			out[k] = deepCopy(nested)
+			continue
+		}
+		out[k] = val
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+func TestStripServerFields(t *testing.T) {
+	svc := manifest.Manifest{
+		"kind": "Service",
+		"metadata": map[string]interface{}{
+			"name":            "web",
+			"resourceVersion": "123",
+			"uid":             "abc",
+		},
+		"spec": map[string]interface{}{
+			"clusterIP":  "10.0.0.1",
+			"clusterIPs": []interface{}{"10.0.0.1"},
+			"type":       "ClusterIP",
+		},
+		"status": map[string]interface{}{"loadBalancer": map[string]interface{}{}},
+	}
+
+	out := StripServerFields(svc)
+	spec := out["spec"].(map[string]interface{})
+
+	if _, ok := spec["clusterIP"]; ok {
+		t.Errorf("expected spec.clusterIP to be stripped from a Service, got %v", spec)
+	}
+	if _, ok := spec["clusterIPs"]; ok {
+		t.Errorf("expected spec.clusterIPs to be stripped from a Service, got %v", spec)
+	}
+	if spec["type"] != "ClusterIP" {
+		t.Errorf("expected unrelated spec fields to survive, got %v", spec)
+	}
+	if _, ok := out["status"]; ok {
+		t.Errorf("expected status to be stripped, got %v", out)
+	}
+
+	pod := manifest.Manifest{
+		"kind": "Pod",
+		"spec": map[string]interface{}{
+			"nodeName":      "node-1",
+			"dnsPolicy":     "ClusterFirst",
+			"schedulerName": "default-scheduler",
+			"containers":    []interface{}{},
+		},
+	}
+	out = StripServerFields(pod)
+	spec = out["spec"].(map[string]interface{})
+	for _, f := range []string{"nodeName", "dnsPolicy", "schedulerName"} {
+		if _, ok := spec[f]; ok {
+			t.Errorf("expected spec.%s to be stripped from a Pod, got %v", f, spec)
+		}
+	}
+	if _, ok := spec["containers"]; !ok {
+		t.Errorf("expected unrelated spec fields to survive, got %v", spec)
+	}
+
+	t.Run("fields not in the defaulted list for a kind are untouched", func(t *testing.T) {
+		cm := manifest.Manifest{
+			"kind": "ConfigMap",
+			"spec": map[string]interface{}{"clusterIP": "kept"},
+		}
+		out := StripServerFields(cm)
+		if out["spec"].(map[string]interface{})["clusterIP"] != "kept" {
+			t.Errorf("expected a kind without a defaulted-field entry to be left alone, got %v", out)
+		}
+	})
+}
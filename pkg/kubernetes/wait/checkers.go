@@ -0,0 +1,157 @@
+package wait
+
+import (
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+// nestedValue walks a dotted path of nested maps, returning nil if any
+// segment is missing
+func nestedValue(m manifest.Manifest, path ...string) interface{} {
+	var cur interface{} = map[string]interface{}(m)
+	for _, p := range path {
+		asMap, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur, ok = asMap[p]
+		if !ok {
+			return nil
+		}
+	}
+	return cur
+}
+
+func nestedInt(m manifest.Manifest, path ...string) (int64, bool) {
+	v := nestedValue(m, path...)
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), true
+	case int:
+		return int64(n), true
+	default:
+		return 0, false
+	}
+}
+
+func nestedString(m manifest.Manifest, path ...string) (string, bool) {
+	v := nestedValue(m, path...)
+	s, ok := v.(string)
+	return s, ok
+}
+
+func nestedSlice(m manifest.Manifest, path ...string) ([]interface{}, bool) {
+	v := nestedValue(m, path...)
+	s, ok := v.([]interface{})
+	return s, ok
+}
+
+// conditionStatus returns the `status` of the given `status.conditions`
+// entry whose `type` matches, if present
+func conditionStatus(m manifest.Manifest, conditionType string) (string, bool) {
+	conditions, ok := nestedSlice(m, "status", "conditions")
+	if !ok {
+		return "", false
+	}
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if cond["type"] != conditionType {
+			continue
+		}
+		status, ok := cond["status"].(string)
+		return status, ok
+	}
+	return "", false
+}
+
+// fallbackReady is used for kinds without a registered checker: ready once
+// status.observedGeneration catches up with metadata.generation, or
+// immediately ready if either field is absent
+func fallbackReady(m manifest.Manifest) (bool, error) {
+	generation, hasGeneration := nestedInt(m, "metadata", "generation")
+	observed, hasObserved := nestedInt(m, "status", "observedGeneration")
+	if !hasGeneration || !hasObserved {
+		return true, nil
+	}
+	return observed >= generation, nil
+}
+
+func deploymentReady(m manifest.Manifest) (bool, error) {
+	if ready, err := generationCaughtUp(m); err != nil || !ready {
+		return ready, err
+	}
+
+	replicas, _ := nestedInt(m, "spec", "replicas")
+	updated, _ := nestedInt(m, "status", "updatedReplicas")
+	available, _ := nestedInt(m, "status", "readyReplicas")
+
+	return updated == replicas && available == replicas, nil
+}
+
+func statefulSetReady(m manifest.Manifest) (bool, error) {
+	if ready, err := generationCaughtUp(m); err != nil || !ready {
+		return ready, err
+	}
+
+	replicas, _ := nestedInt(m, "spec", "replicas")
+	updated, _ := nestedInt(m, "status", "updatedReplicas")
+	ready2, _ := nestedInt(m, "status", "readyReplicas")
+
+	current, _ := nestedString(m, "status", "currentRevision")
+	update, _ := nestedString(m, "status", "updateRevision")
+
+	return updated == replicas && ready2 == replicas && current == update, nil
+}
+
+func daemonSetReady(m manifest.Manifest) (bool, error) {
+	if ready, err := generationCaughtUp(m); err != nil || !ready {
+		return ready, err
+	}
+
+	desired, _ := nestedInt(m, "status", "desiredNumberScheduled")
+	updated, _ := nestedInt(m, "status", "updatedNumberScheduled")
+	available, _ := nestedInt(m, "status", "numberAvailable")
+
+	return updated == desired && available == desired, nil
+}
+
+func podReady(m manifest.Manifest) (bool, error) {
+	status, ok := conditionStatus(m, "Ready")
+	return ok && status == "True", nil
+}
+
+func serviceReady(m manifest.Manifest) (bool, error) {
+	t, _ := nestedString(m, "spec", "type")
+	if t != "LoadBalancer" {
+		return true, nil
+	}
+
+	ingress, ok := nestedSlice(m, "status", "loadBalancer", "ingress")
+	return ok && len(ingress) > 0, nil
+}
+
+func jobReady(m manifest.Manifest) (bool, error) {
+	status, ok := conditionStatus(m, "Complete")
+	return ok && status == "True", nil
+}
+
+func pvcReady(m manifest.Manifest) (bool, error) {
+	phase, ok := nestedString(m, "status", "phase")
+	return ok && phase == "Bound", nil
+}
+
+func crdReady(m manifest.Manifest) (bool, error) {
+	established, _ := conditionStatus(m, "Established")
+	accepted, _ := conditionStatus(m, "NamesAccepted")
+	return established == "True" && accepted == "True", nil
+}
+
+func generationCaughtUp(m manifest.Manifest) (bool, error) {
+	generation, _ := nestedInt(m, "metadata", "generation")
+	observed, _ := nestedInt(m, "status", "observedGeneration")
+	return observed >= generation, nil
+}
@@ -0,0 +1,67 @@
+package wait
+
+import (
+	"testing"
+
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+func deployment(replicas, updated, ready int64) manifest.Manifest {
+	return manifest.Manifest{
+		"spec": map[string]interface{}{
+			"replicas": replicas,
+		},
+		"status": map[string]interface{}{
+			"updatedReplicas": updated,
+			"readyReplicas":   ready,
+		},
+	}
+}
+
+func TestDeploymentReady(t *testing.T) {
+	cases := []struct {
+		name string
+		m    manifest.Manifest
+		want bool
+	}{
+		{"fully rolled out", deployment(3, 3, 3), true},
+		{"still updating", deployment(3, 2, 3), false},
+		{"not yet ready", deployment(3, 3, 1), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ready, err := deploymentReady(c.m)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ready != c.want {
+				t.Errorf("deploymentReady() = %v, want %v", ready, c.want)
+			}
+		})
+	}
+}
+
+func TestFallbackReady(t *testing.T) {
+	caughtUp := manifest.Manifest{
+		"metadata": map[string]interface{}{"generation": int64(2)},
+		"status":   map[string]interface{}{"observedGeneration": int64(2)},
+	}
+	behind := manifest.Manifest{
+		"metadata": map[string]interface{}{"generation": int64(2)},
+		"status":   map[string]interface{}{"observedGeneration": int64(1)},
+	}
+	missingStatus := manifest.Manifest{
+		"metadata": map[string]interface{}{"generation": int64(2)},
+	}
+
+	if ready, err := fallbackReady(caughtUp); err != nil || !ready {
+		t.Errorf("expected caught-up generation to be ready, got ready=%v err=%v", ready, err)
+	}
+	if ready, err := fallbackReady(behind); err != nil || ready {
+		t.Errorf("expected stale generation to not be ready, got ready=%v err=%v", ready, err)
+	}
+	if ready, err := fallbackReady(missingStatus); err != nil || !ready {
+		t.Errorf("expected a kind without observedGeneration to be immediately ready, got ready=%v err=%v", ready, err)
+	}
+}
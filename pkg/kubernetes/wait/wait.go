@@ -0,0 +1,198 @@
+// Package wait implements post-apply readiness checks, blocking until the
+// applied resources actually reach their desired state
+package wait
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+// poolSize bounds how many `kubectl get` polls run concurrently, mirroring
+// the bounded pool used for orphan scanning
+const poolSize = 10
+
+// initialBackoff and maxBackoff bound the exponential backoff between polls
+// of a single object
+const (
+	initialBackoff = 500 * time.Millisecond
+	maxBackoff     = 10 * time.Second
+)
+
+// Opts configures Wait
+type Opts struct {
+	// Timeout is the deadline after which Wait gives up and returns an error
+	// listing the objects that never became ready
+	Timeout time.Duration
+}
+
+// NotReadyError is returned when Wait's deadline passes before every object
+// became ready
+type NotReadyError struct {
+	Objects []manifest.Identifier
+}
+
+func (e NotReadyError) Error() string {
+	names := make([]string, 0, len(e.Objects))
+	for _, id := range e.Objects {
+		names = append(names, fmt.Sprintf("%s/%s", id.Kind, id.Name))
+	}
+	return fmt.Sprintf("timed out waiting for readiness of: %s", strings.Join(names, ", "))
+}
+
+// checker reports whether the given object has reached its ready state. A
+// nil error with false means "still waiting", not a failure
+type checker func(obj manifest.Manifest) (bool, error)
+
+// checkers holds the per-kind readiness checks, in the style of Helm's kube
+// client
+var checkers = map[string]checker{
+	"Deployment":               deploymentReady,
+	"StatefulSet":              statefulSetReady,
+	"DaemonSet":                daemonSetReady,
+	"Pod":                      podReady,
+	"Service":                  serviceReady,
+	"Job":                      jobReady,
+	"CronJob":                  func(manifest.Manifest) (bool, error) { return true, nil },
+	"PersistentVolumeClaim":    pvcReady,
+	"CustomResourceDefinition": crdReady,
+}
+
+// For waits on resources scheduled for deletion (i.e. after pruning), an
+// object is "ready" once it can no longer be found
+func deletedReady(ctl client.Interface, id manifest.Identifier) (bool, error) {
+	_, err := ctl.Get(id.Namespace, id.Kind, id.Name)
+	if client.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// Wait polls the cluster until every object in `state` is ready, or until
+// opts.Timeout elapses
+func Wait(ctl client.Interface, state manifest.List, opts Opts) error {
+	return poll(state, opts, func(m manifest.Manifest) (bool, error) {
+		id := m.Identifier()
+		live, err := ctl.Get(id.Namespace, id.Kind, id.Name)
+		if client.IsNotFound(err) {
+			// not yet created by the apiserver - still pending, not a failure
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		check, ok := checkers[m.Kind()]
+		if !ok {
+			check = fallbackReady
+		}
+		return check(live)
+	})
+}
+
+// WaitDeleted polls the cluster until every object in `pruned` has actually
+// disappeared, used after prune deletes orphaned objects
+func WaitDeleted(ctl client.Interface, pruned manifest.List, opts Opts) error {
+	return poll(pruned, opts, func(m manifest.Manifest) (bool, error) {
+		return deletedReady(ctl, m.Identifier())
+	})
+}
+
+func poll(state manifest.List, opts Opts, check checker) error {
+	deadline := time.Now().Add(opts.Timeout)
+
+	pending := make([]manifest.Manifest, len(state))
+	copy(pending, state)
+
+	for round := 0; len(pending) > 0; round++ {
+		if opts.Timeout > 0 && time.Now().After(deadline) {
+			ids := make([]manifest.Identifier, 0, len(pending))
+			for _, m := range pending {
+				ids = append(ids, m.Identifier())
+			}
+			return NotReadyError{Objects: ids}
+		}
+
+		still, err := pollOnce(pending, check)
+		if err != nil {
+			return err
+		}
+		pending = still
+
+		if len(pending) > 0 {
+			time.Sleep(backoff(round))
+		}
+	}
+
+	return nil
+}
+
+// pollOnce checks every pending object once, in parallel bounded by
+// poolSize, and returns the objects still not ready
+func pollOnce(pending []manifest.Manifest, check checker) ([]manifest.Manifest, error) {
+	type result struct {
+		obj   manifest.Manifest
+		ready bool
+		err   error
+	}
+
+	jobs := make(chan manifest.Manifest)
+	results := make(chan result)
+
+	workers := poolSize
+	if workers > len(pending) {
+		workers = len(pending)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range jobs {
+				ready, err := check(obj)
+				results <- result{obj: obj, ready: ready, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, obj := range pending {
+			jobs <- obj
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	still := []manifest.Manifest{}
+	var lastErr error
+	for res := range results {
+		if res.err != nil {
+			lastErr = errors.Wrapf(res.err, "checking readiness of %s/%s", res.obj.Kind(), res.obj.Metadata().Name())
+			continue
+		}
+		if !res.ready {
+			still = append(still, res.obj)
+		}
+	}
+
+	return still, lastErr
+}
+
+func backoff(attempt int) time.Duration {
+	d := initialBackoff << uint(attempt)
+	if d > maxBackoff || d <= 0 {
+		return maxBackoff
+	}
+	return d
+}
@@ -0,0 +1,96 @@
+package wait
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/tanka/pkg/kubernetes/client"
+	"github.com/grafana/tanka/pkg/kubernetes/manifest"
+)
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, initialBackoff},
+		{1, 2 * initialBackoff},
+		{2, 4 * initialBackoff},
+		{20, maxBackoff}, // large attempts must cap, not overflow into a negative/zero duration
+	}
+
+	for _, c := range cases {
+		if got := backoff(c.attempt); got != c.want {
+			t.Errorf("backoff(%d) = %v, want %v", c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestDeletedReady(t *testing.T) {
+	// Get returns NotFoundError, i.e. "nothing here" - which is exactly what
+	// deletedReady should treat as ready
+	ctl := &fakeClient{getErr: client.NotFoundError{Kind: "ConfigMap", Name: "foo", Namespace: "default"}}
+	ready, err := deletedReady(ctl, manifest.Identifier{Kind: "ConfigMap", Name: "foo", Namespace: "default"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ready {
+		t.Errorf("expected object absent from a NotFoundError to be reported ready")
+	}
+}
+
+// fakeClient is a minimal client.Interface implementation that lets tests
+// control what Get returns, without shelling out to kubectl
+type fakeClient struct {
+	getResult manifest.Manifest
+	getErr    error
+}
+
+var _ client.Interface = (*fakeClient)(nil)
+
+func (f *fakeClient) Info() (*client.Info, error)                   { return &client.Info{}, nil }
+func (f *fakeClient) Apply(manifest.List, client.ApplyOpts) error   { return nil }
+func (f *fakeClient) DiffServerSide(manifest.List) (*string, error) { return nil, nil }
+func (f *fakeClient) DiffServerSideApply(manifest.List, string, bool) (*string, error) {
+	return nil, nil
+}
+func (f *fakeClient) GetByLabels(string, string, map[string]string) (manifest.List, error) {
+	return nil, nil
+}
+func (f *fakeClient) Get(namespace, kind, name string) (manifest.Manifest, error) {
+	return f.getResult, f.getErr
+}
+func (f *fakeClient) Delete(manifest.List) error                               { return nil }
+func (f *fakeClient) GetInventory(string, string) ([]client.Identifier, error) { return nil, nil }
+func (f *fakeClient) SaveInventory(string, string, []client.Identifier) error  { return nil }
+func (f *fakeClient) APIResources() ([]client.APIResource, error)              { return nil, nil }
+
+func TestWait_ChecksTheLiveObjectNotTheDesiredManifest(t *testing.T) {
+	// the desired manifest carries no status at all - if Wait checked it
+	// directly instead of fetching the live object, podReady would never
+	// see a Ready condition and this would time out
+	desired := manifest.List{
+		manifest.Manifest{
+			"kind": "Pod",
+			"metadata": map[string]interface{}{
+				"name":      "app",
+				"namespace": "default",
+			},
+		},
+	}
+
+	fc := &fakeClient{
+		getResult: manifest.Manifest{
+			"kind": "Pod",
+			"status": map[string]interface{}{
+				"conditions": []interface{}{
+					map[string]interface{}{"type": "Ready", "status": "True"},
+				},
+			},
+		},
+	}
+
+	if err := Wait(fc, desired, Opts{Timeout: time.Second}); err != nil {
+		t.Fatalf("expected Wait to succeed once the live object reports Ready, got %v", err)
+	}
+}
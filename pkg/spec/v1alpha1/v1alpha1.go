@@ -0,0 +1,65 @@
+// Package v1alpha1 holds the spec.json schema of a Tanka environment
+package v1alpha1
+
+import "fmt"
+
+// Kind is the type of the tanka config, used to identify it in spec.json
+const Kind = "Environment"
+
+// Config represents the contents of spec.json
+type Config struct {
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Metadata   Metadata `json:"metadata"`
+	Spec       Spec     `json:"spec"`
+}
+
+// Metadata contains the convention metadata of a Tanka environment
+type Metadata struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// NameLabel returns the value of the label used to mark resources as
+// belonging to this environment
+func (m Metadata) NameLabel() string {
+	return fmt.Sprintf("%s/%s", m.Namespace, m.Name)
+}
+
+// Spec contains the cluster and behavior configuration of an environment
+type Spec struct {
+	// APIServer is the server to apply to / diff against
+	APIServer string `json:"apiServer"`
+	// Namespace is the default namespace manifests are applied to
+	Namespace string `json:"namespace"`
+
+	// DiffStrategy used to diff. `native`, `subset`, `server-side` or
+	// `read-only`. When unset, `New()` picks one from the apiserver's version
+	DiffStrategy string `json:"diffStrategy,omitempty"`
+
+	// ApplyStrategy used to apply. `native` or `server-side`. Defaults to
+	// `native`
+	ApplyStrategy string `json:"applyStrategy,omitempty"`
+
+	// FieldManager is the identity used when applying with Server-Side
+	// Apply. Defaults to "tanka"
+	FieldManager string `json:"fieldManager,omitempty"`
+
+	// ForceConflicts instructs the apiserver to take ownership of fields
+	// already managed by another field manager instead of erroring out
+	ForceConflicts bool `json:"forceConflicts,omitempty"`
+
+	// OrphanResources narrows the set of API resources scanned for orphans
+	// during `tk apply`. Kinds/groups not matched by Includes (when set) or
+	// matched by Excludes are skipped entirely
+	OrphanResources ResourceFilter `json:"orphanResources,omitempty"`
+}
+
+// ResourceFilter allows to include or exclude API resources (kinds and/or
+// their API group) by name
+type ResourceFilter struct {
+	IncludeGroups []string `json:"includeGroups,omitempty"`
+	ExcludeGroups []string `json:"excludeGroups,omitempty"`
+	IncludeKinds  []string `json:"includeKinds,omitempty"`
+	ExcludeKinds  []string `json:"excludeKinds,omitempty"`
+}